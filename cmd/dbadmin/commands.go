@@ -0,0 +1,151 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/moov-io/watchman/internal/database"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+// databaseType normalizes cfg.DatabaseType the same way database.New does:
+// an empty value defaults to mysql.
+func databaseType(cfg Config) string {
+	if cfg.DatabaseType == "" {
+		return "mysql"
+	}
+	return cfg.DatabaseType
+}
+
+// driverAndDSN returns the sql.Open driver name and DSN for the backend
+// named by cfg.DatabaseType.
+func driverAndDSN(cfg Config) (string, string, error) {
+	switch databaseType(cfg) {
+	case "mysql":
+		dsn := fmt.Sprintf("%s:%s@%s/%s?timeout=30s&tls=false&charset=utf8mb4&parseTime=true&sql_mode=ALLOW_INVALID_DATES", cfg.MySQLUser, cfg.MySQLPassword, cfg.MySQLAddress, cfg.MySQLDatabase)
+		return "mysql", dsn, nil
+
+	case "postgres":
+		sslmode := cfg.PostgresSSLMode
+		if sslmode == "" {
+			sslmode = "disable"
+		}
+		dsn := fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=%s", cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresHost, cfg.PostgresDatabase, sslmode)
+		return "postgres", dsn, nil
+
+	default:
+		return "", "", fmt.Errorf("unknown database type %q", cfg.DatabaseType)
+	}
+}
+
+func open(cfg Config) (*sql.DB, error) {
+	driver, dsn, err := driverAndDSN(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open(driver, dsn)
+}
+
+// setup creates the database (using the root password) if it doesn't already
+// exist, then runs all migrations against it. Only mysql supports creating
+// the database this way, so a postgres database is expected to already
+// exist -- setup just migrates it.
+func setup(cfg Config) error {
+	if databaseType(cfg) == "mysql" {
+		root, err := sql.Open("mysql", fmt.Sprintf("root:%s@%s/?timeout=30s&tls=false", cfg.MySQLRootPassword, cfg.MySQLAddress))
+		if err != nil {
+			return fmt.Errorf("connecting as root: %v", err)
+		}
+		defer root.Close()
+
+		if _, err := root.Exec(fmt.Sprintf("create database if not exists %s", cfg.MySQLDatabase)); err != nil {
+			return fmt.Errorf("creating database %s: %v", cfg.MySQLDatabase, err)
+		}
+	}
+
+	return migrate(cfg, "")
+}
+
+// migrate applies every pending migration against the configured database,
+// stopping after target if it's non-empty.
+func migrate(cfg Config, target string) error {
+	db, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	opts := database.MigrateOptions{TargetVersion: target}
+	if err := database.Migrate(context.Background(), databaseType(cfg), db, opts); err != nil {
+		return fmt.Errorf("migrating: %v", err)
+	}
+	return nil
+}
+
+// rollback undoes every migration applied after target, in reverse order.
+func rollback(cfg Config, target string) error {
+	db, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	opts := database.MigrateOptions{TargetVersion: target, Down: true}
+	if err := database.Migrate(context.Background(), databaseType(cfg), db, opts); err != nil {
+		return fmt.Errorf("rolling back: %v", err)
+	}
+	return nil
+}
+
+// status prints every migration along with whether it has been applied.
+func status(cfg Config) error {
+	db, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	dbType := databaseType(cfg)
+	applied, err := database.Applied(context.Background(), dbType, db)
+	if err != nil {
+		return fmt.Errorf("reading applied migrations: %v", err)
+	}
+	appliedSet := make(map[string]bool, len(applied))
+	for _, name := range applied {
+		appliedSet[name] = true
+	}
+
+	for _, name := range database.MigrationNames(dbType) {
+		state := "pending"
+		if appliedSet[name] {
+			state = "applied"
+		}
+		fmt.Printf("%-60s %s\n", name, state)
+	}
+	return nil
+}
+
+// reset truncates every Watchman table so a shared dev database can be
+// reused between test runs without a docker restart. It never drops the
+// database itself.
+func reset(cfg Config) error {
+	db, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, table := range database.TableNames() {
+		if _, err := db.Exec(fmt.Sprintf("truncate table %s", table)); err != nil {
+			return fmt.Errorf("truncating %s: %v", table, err)
+		}
+	}
+	return nil
+}
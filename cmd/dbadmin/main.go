@@ -0,0 +1,80 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+// Command dbadmin runs Watchman's database migrations out-of-band from the
+// server process, so deploys and CI seeding don't depend on the first
+// Connect() call happening at the right time.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Config holds the connection details dbadmin needs to setup, migrate,
+// inspect, or reset a Watchman database. Only the section matching
+// DatabaseType is read, mirroring database.Config.
+type Config struct {
+	DatabaseType string `envconfig:"DATABASE_TYPE"`
+
+	MySQLUser         string `envconfig:"MYSQL_USER"`
+	MySQLPassword     string `envconfig:"MYSQL_PASSWORD"`
+	MySQLAddress      string `envconfig:"MYSQL_ADDRESS"`
+	MySQLDatabase     string `envconfig:"MYSQL_DATABASE"`
+	MySQLRootPassword string `envconfig:"MYSQL_ROOT_PASSWORD"`
+
+	PostgresUser     string `envconfig:"POSTGRES_USER"`
+	PostgresPassword string `envconfig:"POSTGRES_PASSWORD"`
+	PostgresHost     string `envconfig:"POSTGRES_HOST"`
+	PostgresDatabase string `envconfig:"POSTGRES_DATABASE"`
+	PostgresSSLMode  string `envconfig:"POSTGRES_SSLMODE"`
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: dbadmin <setup|migrate|rollback|status|reset> [target migration]")
+		os.Exit(1)
+	}
+
+	var cfg Config
+	if err := envconfig.Process("", &cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "dbadmin: reading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	target := ""
+	if len(args) > 1 {
+		target = args[1]
+	}
+
+	var err error
+	switch args[0] {
+	case "setup":
+		err = setup(cfg)
+	case "migrate":
+		err = migrate(cfg, target)
+	case "rollback":
+		if target == "" {
+			fmt.Fprintln(os.Stderr, "usage: dbadmin rollback <target migration>")
+			os.Exit(1)
+		}
+		err = rollback(cfg, target)
+	case "status":
+		err = status(cfg)
+	case "reset":
+		err = reset(cfg)
+	default:
+		fmt.Fprintf(os.Stderr, "dbadmin: unknown subcommand %q\n", args[0])
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dbadmin: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+)
+
+// MySQLConfig holds the connection details for the mysql backend.
+type MySQLConfig struct {
+	User, Password, Address, Database string
+}
+
+// PostgresConfig holds the connection details for the postgres backend.
+type PostgresConfig struct {
+	User, Password, Host, Database, SSLMode string
+}
+
+// Config selects which backend Watchman stores its data in and holds the
+// connection details for it. Only the section matching DatabaseType is read.
+type Config struct {
+	DatabaseType string
+
+	MySQL    *MySQLConfig
+	Postgres *PostgresConfig
+}
+
+// New returns a *sql.DB for the backend named by config.DatabaseType, with
+// migrations already applied. An empty DatabaseType defaults to mysql.
+func New(logger log.Logger, config Config) (*sql.DB, error) {
+	switch config.DatabaseType {
+	case "", "mysql":
+		if config.MySQL == nil {
+			return nil, fmt.Errorf("database: missing mysql config")
+		}
+		return mysqlConnection(logger, config.MySQL.User, config.MySQL.Password, config.MySQL.Address, config.MySQL.Database).Connect()
+
+	case "postgres":
+		if config.Postgres == nil {
+			return nil, fmt.Errorf("database: missing postgres config")
+		}
+		return postgresConnection(logger, config.Postgres.User, config.Postgres.Password, config.Postgres.Host, config.Postgres.Database, config.Postgres.SSLMode).Connect()
+
+	default:
+		return nil, fmt.Errorf("database: unknown database type %q", config.DatabaseType)
+	}
+}
+
+// tableNames lists every table Watchman's migrations create, in no
+// particular order. cmd/dbadmin uses this for its reset subcommand so it
+// doesn't need to duplicate DDL.
+var tableNames = []string{
+	"customer_name_watches",
+	"customer_status",
+	"customer_watches",
+	"company_name_watches",
+	"company_status",
+	"company_watches",
+	"ofac_download_stats",
+	"webhook_stats",
+}
+
+// TableNames returns every table Watchman's migrations create.
+func TableNames() []string {
+	out := make([]string, len(tableNames))
+	copy(out, tableNames)
+	return out
+}
+
+// MigrationNames returns the ordered list of migration names that will be
+// applied for the given database type, so callers like cmd/dbadmin can
+// report status without duplicating DDL.
+func MigrationNames(databaseType string) []string {
+	migrations := migrationsFor(databaseType)
+	names := make([]string, len(migrations))
+	for i, m := range migrations {
+		names[i] = m.Name
+	}
+	return names
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// mysql error numbers we classify below.
+// See https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+const (
+	mysqlErrDuplicate = 1062
+	mysqlErrDeadlock  = 1213
+)
+
+// postgres SQLSTATE codes we classify below.
+// See https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	postgresErrDuplicate = "23505"
+	postgresErrDeadlock  = "40P01"
+)
+
+// ErrDatabaseOp wraps an error returned from a database operation with the
+// operation and table it was attempting, so callers can log useful context
+// without every repository formatting its own error strings.
+type ErrDatabaseOp struct {
+	Op    string
+	Table string
+	Err   error
+}
+
+func (e *ErrDatabaseOp) Error() string {
+	return fmt.Sprintf("database: %s on %s: %v", e.Op, e.Table, e.Err)
+}
+
+func (e *ErrDatabaseOp) Unwrap() error {
+	return e.Err
+}
+
+// Wrap annotates err with the operation and table it occurred on. It returns
+// nil if err is nil so callers can write `return database.Wrap(op, table, err)`
+// unconditionally.
+func Wrap(op, table string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ErrDatabaseOp{Op: op, Table: table, Err: err}
+}
+
+// IsNoRows returns true if err is (or wraps) sql.ErrNoRows.
+func IsNoRows(err error) bool {
+	return errors.Is(err, sql.ErrNoRows)
+}
+
+// IsDuplicate returns true if err is a MySQL duplicate-key error (1062) or
+// a Postgres unique_violation (23505).
+func IsDuplicate(err error) bool {
+	var mysqlErr *gomysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDuplicate
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code) == postgresErrDuplicate
+	}
+
+	return false
+}
+
+// IsDeadlock returns true if err is a MySQL deadlock error (1213) or a
+// Postgres deadlock_detected (40P01).
+func IsDeadlock(err error) bool {
+	var mysqlErr *gomysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDeadlock
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code) == postgresErrDeadlock
+	}
+
+	return false
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestIsNoRows(t *testing.T) {
+	if !IsNoRows(sql.ErrNoRows) {
+		t.Error("expected sql.ErrNoRows to be classified as IsNoRows")
+	}
+	if !IsNoRows(Wrap("select", "customer_watches", sql.ErrNoRows)) {
+		t.Error("expected a wrapped sql.ErrNoRows to be classified as IsNoRows")
+	}
+	if IsNoRows(errors.New("some other error")) {
+		t.Error("expected an unrelated error not to be classified as IsNoRows")
+	}
+	if IsNoRows(nil) {
+		t.Error("expected nil not to be classified as IsNoRows")
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	mysqlErr := &gomysql.MySQLError{Number: mysqlErrDuplicate, Message: "Duplicate entry"}
+	if !IsDuplicate(mysqlErr) {
+		t.Error("expected mysql error 1062 to be classified as IsDuplicate")
+	}
+
+	pqErr := &pq.Error{Code: postgresErrDuplicate}
+	if !IsDuplicate(pqErr) {
+		t.Error("expected postgres SQLSTATE 23505 to be classified as IsDuplicate")
+	}
+
+	otherMySQLErr := &gomysql.MySQLError{Number: mysqlErrDeadlock, Message: "Deadlock found"}
+	if IsDuplicate(otherMySQLErr) {
+		t.Error("expected mysql error 1213 not to be classified as IsDuplicate")
+	}
+
+	otherPQErr := &pq.Error{Code: postgresErrDeadlock}
+	if IsDuplicate(otherPQErr) {
+		t.Error("expected postgres SQLSTATE 40P01 not to be classified as IsDuplicate")
+	}
+
+	if IsDuplicate(fmt.Errorf("some other error")) {
+		t.Error("expected an unrelated error not to be classified as IsDuplicate")
+	}
+}
+
+func TestIsDeadlock(t *testing.T) {
+	mysqlErr := &gomysql.MySQLError{Number: mysqlErrDeadlock, Message: "Deadlock found"}
+	if !IsDeadlock(mysqlErr) {
+		t.Error("expected mysql error 1213 to be classified as IsDeadlock")
+	}
+
+	pqErr := &pq.Error{Code: postgresErrDeadlock}
+	if !IsDeadlock(pqErr) {
+		t.Error("expected postgres SQLSTATE 40P01 to be classified as IsDeadlock")
+	}
+
+	otherMySQLErr := &gomysql.MySQLError{Number: mysqlErrDuplicate, Message: "Duplicate entry"}
+	if IsDeadlock(otherMySQLErr) {
+		t.Error("expected mysql error 1062 not to be classified as IsDeadlock")
+	}
+
+	if IsDeadlock(fmt.Errorf("some other error")) {
+		t.Error("expected an unrelated error not to be classified as IsDeadlock")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	if err := Wrap("select", "customer_watches", nil); err != nil {
+		t.Errorf("expected Wrap to return nil for a nil err, got %v", err)
+	}
+
+	wrapped := Wrap("select", "customer_watches", sql.ErrNoRows)
+	if wrapped == nil {
+		t.Fatal("expected Wrap to return a non-nil error")
+	}
+
+	want := "database: select on customer_watches: sql: no rows in result set"
+	if wrapped.Error() != want {
+		t.Errorf("Error() = %q, want %q", wrapped.Error(), want)
+	}
+
+	if !errors.Is(wrapped, sql.ErrNoRows) {
+		t.Error("expected Wrap's error to unwrap to sql.ErrNoRows")
+	}
+}
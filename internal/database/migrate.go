@@ -0,0 +1,345 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// mysqlErrNoSuchTable and postgresErrUndefinedTable are the driver error
+// codes for "that table doesn't exist", used by backfillFromLegacyTable to
+// tell a genuinely missing legacyMigrationsTable apart from a transient
+// query failure.
+const (
+	mysqlErrNoSuchTable       = 1146
+	postgresErrUndefinedTable = "42P01"
+)
+
+// isMissingTableError returns true if err indicates the query failed
+// because the table it referenced doesn't exist, rather than some other
+// (possibly transient) failure.
+func isMissingTableError(err error) bool {
+	var mysqlErr *gomysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrNoSuchTable
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code) == postgresErrUndefinedTable
+	}
+
+	return false
+}
+
+// migration is one schema change Watchman knows how to apply, and usually
+// how to reverse. Down is left empty for migrations we consider
+// irreversible (e.g. ones that would destroy data); rollbackTo refuses to
+// walk past one of those.
+type migration struct {
+	Name string
+	Up   string
+	Down string
+}
+
+// MigrateOptions controls how Migrate applies a backend's migration list.
+// The zero value applies every pending migration, which is what New does
+// on every connect.
+type MigrateOptions struct {
+	// TargetVersion stops a forward migration once this named migration
+	// has been applied, or stops a rollback once everything applied
+	// after this named migration has been undone. An empty TargetVersion
+	// means "all the way".
+	TargetVersion string
+
+	// Down rolls migrations back to TargetVersion instead of applying
+	// pending migrations forward.
+	Down bool
+}
+
+// schemaMigrationsTable is the bookkeeping table Watchman now uses to
+// record which migrations have been applied, so a rollback can look up the
+// Down statement for anything it finds applied.
+const schemaMigrationsTable = "schema_migrations"
+
+// legacyMigrationsTable is the bookkeeping table lopezator/migrator created
+// and maintained before Watchman owned its own. Any database migrated by
+// that code already has this table fully populated and schema_migrations
+// empty, so the first
+// connection against it must treat legacyMigrationsTable as authoritative
+// rather than replaying every migration -- including the non-idempotent
+// "add__denied_persons__to__ofac_download_stats" ALTER TABLE.
+const legacyMigrationsTable = "migrations"
+
+func placeholder(databaseType string, pos int) string {
+	if databaseType == "postgres" {
+		return fmt.Sprintf("$%d", pos)
+	}
+	return "?"
+}
+
+// idColumn is the auto-incrementing ordering tiebreaker for
+// schema_migrations: applied_at has only second precision, so migrations
+// applied back-to-back in the same Connect() call (the common case) can
+// share a timestamp.
+func idColumn(databaseType string) string {
+	if databaseType == "postgres" {
+		return "id serial primary key"
+	}
+	return "id integer primary key auto_increment"
+}
+
+func ensureSchemaMigrationsTable(ctx context.Context, db *sql.DB, databaseType string) error {
+	query := fmt.Sprintf(`create table if not exists %s(%s, name varchar(255) unique, applied_at timestamp not null default current_timestamp)`, schemaMigrationsTable, idColumn(databaseType))
+	_, err := db.ExecContext(ctx, query)
+	return Wrap("create", schemaMigrationsTable, err)
+}
+
+// appliedNames returns every migration name recorded in schema_migrations,
+// oldest first.
+func appliedNames(ctx context.Context, db *sql.DB, databaseType string) ([]string, error) {
+	if err := ensureSchemaMigrationsTable(ctx, db, databaseType); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("select name from %s order by id asc", schemaMigrationsTable))
+	if err != nil {
+		return nil, Wrap("select", schemaMigrationsTable, err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// backfillFromLegacyTable copies migration names already recorded in
+// legacyMigrationsTable into schema_migrations, in our canonical migration
+// order, the first time we see a database that was migrated before
+// Watchman owned its own bookkeeping. It's a no-op once schema_migrations
+// has any rows, and a no-op on a brand new database that never had
+// legacyMigrationsTable to begin with.
+func backfillFromLegacyTable(ctx context.Context, db *sql.DB, databaseType string, migrations []migration) error {
+	var count int
+	row := db.QueryRowContext(ctx, fmt.Sprintf("select count(*) from %s", schemaMigrationsTable))
+	if err := row.Scan(&count); err != nil {
+		return Wrap("count", schemaMigrationsTable, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("select name from %s", legacyMigrationsTable))
+	if err != nil {
+		if isMissingTableError(err) {
+			// A fresh database that never had legacyMigrationsTable --
+			// nothing to backfill, not an error.
+			return nil
+		}
+		return Wrap("select", legacyMigrationsTable, err)
+	}
+	defer rows.Close()
+
+	legacy := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		legacy[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	insert := fmt.Sprintf("insert into %s(name) values (%s)", schemaMigrationsTable, placeholder(databaseType, 1))
+	for _, m := range migrations {
+		if legacy[m.Name] {
+			if _, err := db.ExecContext(ctx, insert, m.Name); err != nil {
+				return Wrap("insert", schemaMigrationsTable, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runMigrations applies or rolls back migrations against db according to
+// opts, recording (or removing) each migration's row in schema_migrations
+// as it goes.
+func runMigrations(ctx context.Context, db *sql.DB, databaseType string, migrations []migration, opts MigrateOptions) error {
+	if err := ensureSchemaMigrationsTable(ctx, db, databaseType); err != nil {
+		return err
+	}
+	if err := backfillFromLegacyTable(ctx, db, databaseType, migrations); err != nil {
+		return fmt.Errorf("database: backfilling %s from %s: %v", schemaMigrationsTable, legacyMigrationsTable, err)
+	}
+
+	if opts.Down {
+		applied, err := appliedNames(ctx, db, databaseType)
+		if err != nil {
+			return err
+		}
+		return rollbackTo(ctx, db, databaseType, migrations, applied, opts.TargetVersion)
+	}
+
+	applied, err := appliedNames(ctx, db, databaseType)
+	if err != nil {
+		return err
+	}
+	appliedSet := make(map[string]bool, len(applied))
+	for _, name := range applied {
+		appliedSet[name] = true
+	}
+	return migrateTo(ctx, db, databaseType, migrations, appliedSet, opts.TargetVersion)
+}
+
+// migrateTo applies every migration not yet recorded in appliedSet, in
+// order, stopping once target has been applied if target is non-empty.
+func migrateTo(ctx context.Context, db *sql.DB, databaseType string, migrations []migration, appliedSet map[string]bool, target string) error {
+	if target != "" && !appliedSet[target] {
+		found := false
+		for _, m := range migrations {
+			if m.Name == target {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("database: target migration %q does not exist", target)
+		}
+	}
+
+	for _, m := range migrations {
+		if !appliedSet[m.Name] {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("database: applying %s: %v", m.Name, err)
+			}
+			insert := fmt.Sprintf("insert into %s(name) values (%s)", schemaMigrationsTable, placeholder(databaseType, 1))
+			if _, err := tx.ExecContext(ctx, insert, m.Name); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("database: recording %s: %v", m.Name, err)
+			}
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+		}
+		if target != "" && m.Name == target {
+			return nil
+		}
+	}
+	return nil
+}
+
+// rollbackTo undoes every applied migration more recent than target, most
+// recently applied first, executing each migration's Down statement and
+// removing its schema_migrations row in a single transaction. An empty
+// target rolls back every migration.
+func rollbackTo(ctx context.Context, db *sql.DB, databaseType string, migrations []migration, applied []string, target string) error {
+	byName := make(map[string]migration, len(migrations))
+	for _, m := range migrations {
+		byName[m.Name] = m
+	}
+
+	var toUndo []migration
+	found := target == ""
+	for i := len(applied) - 1; i >= 0; i-- {
+		name := applied[i]
+		if name == target {
+			found = true
+			break
+		}
+		m, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("database: no migration registered for applied name %q", name)
+		}
+		if m.Down == "" {
+			return fmt.Errorf("database: migration %s has no Down statement and cannot be rolled back", name)
+		}
+		toUndo = append(toUndo, m)
+	}
+	if !found {
+		return fmt.Errorf("database: target migration %q was never applied", target)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	del := fmt.Sprintf("delete from %s where name = %s", schemaMigrationsTable, placeholder(databaseType, 1))
+	for _, m := range toUndo {
+		if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("database: rolling back %s: %v", m.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, del, m.Name); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("database: removing %s from %s: %v", m.Name, schemaMigrationsTable, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Migrate applies or rolls back databaseType's migrations against db
+// according to opts. It's exposed so cmd/dbadmin can target a specific
+// version or roll back without going through New.
+func Migrate(ctx context.Context, databaseType string, db *sql.DB, opts MigrateOptions) error {
+	return runMigrations(ctx, db, databaseType, migrationsFor(databaseType), opts)
+}
+
+// Applied returns the migrations recorded as applied against db, oldest
+// first.
+func Applied(ctx context.Context, databaseType string, db *sql.DB) ([]string, error) {
+	return appliedNames(ctx, db, databaseType)
+}
+
+// Pending returns the migrations registered for databaseType that have not
+// yet been applied to db, in the order Migrate would apply them.
+func Pending(ctx context.Context, databaseType string, db *sql.DB) ([]string, error) {
+	applied, err := appliedNames(ctx, db, databaseType)
+	if err != nil {
+		return nil, err
+	}
+	appliedSet := make(map[string]bool, len(applied))
+	for _, name := range applied {
+		appliedSet[name] = true
+	}
+
+	var pending []string
+	for _, m := range migrationsFor(databaseType) {
+		if !appliedSet[m.Name] {
+			pending = append(pending, m.Name)
+		}
+	}
+	return pending, nil
+}
+
+// migrationsFor returns the ordered migration list for databaseType. An
+// unrecognized databaseType (including the empty string) defaults to mysql,
+// mirroring New.
+func migrationsFor(databaseType string) []migration {
+	switch databaseType {
+	case "postgres":
+		return postgresMigrations
+	default:
+		return mysqlMigrations
+	}
+}
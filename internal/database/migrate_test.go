@@ -0,0 +1,215 @@
+// Copyright 2019 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestMigrateTo_stopsAtTargetVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []migration{
+		{Name: "m1", Up: "create table m1(id int)"},
+		{Name: "m2", Up: "create table m2(id int)"},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migrations[0].Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("insert into schema_migrations(name) values (?)")).
+		WithArgs("m1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := migrateTo(context.Background(), db, "mysql", migrations, map[string]bool{}, "m1"); err != nil {
+		t.Fatalf("migrateTo: %v", err)
+	}
+
+	// m2 must never have been touched, so every expectation above (and
+	// only those) should be satisfied.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet or unexpected expectations: %v", err)
+	}
+}
+
+func TestMigrateTo_unknownTarget(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []migration{{Name: "m1", Up: "create table m1(id int)"}}
+
+	err = migrateTo(context.Background(), db, "mysql", migrations, map[string]bool{}, "does-not-exist")
+	if err == nil {
+		t.Fatal("expected an error for an unknown target migration")
+	}
+
+	// Nothing should have been applied while validating the target.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet or unexpected expectations: %v", err)
+	}
+}
+
+func TestRollbackTo_refusesMigrationWithNoDown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []migration{{Name: "m1", Up: "create table m1(id int)"}} // no Down
+	applied := []string{"m1"}
+
+	err = rollbackTo(context.Background(), db, "mysql", migrations, applied, "")
+	if err == nil {
+		t.Fatal("expected an error rolling back a migration with no Down statement")
+	}
+
+	// rollbackTo should refuse before opening a transaction.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet or unexpected expectations: %v", err)
+	}
+}
+
+func TestRollbackTo_errorsOnUnappliedTarget(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []migration{{Name: "m1", Up: "create table m1(id int)", Down: "drop table m1"}}
+	applied := []string{"m1"}
+
+	err = rollbackTo(context.Background(), db, "mysql", migrations, applied, "never-applied")
+	if err == nil {
+		t.Fatal("expected an error rolling back to a target that was never applied")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet or unexpected expectations: %v", err)
+	}
+}
+
+func TestRollbackTo_undoesInReverseOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []migration{
+		{Name: "m1", Up: "create table m1(id int)", Down: "drop table m1"},
+		{Name: "m2", Up: "create table m2(id int)", Down: "drop table m2"},
+		{Name: "m3", Up: "create table m3(id int)", Down: "drop table m3"},
+	}
+	applied := []string{"m1", "m2", "m3"}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("drop table m3")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("delete from schema_migrations where name = ?")).
+		WithArgs("m3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("drop table m2")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("delete from schema_migrations where name = ?")).
+		WithArgs("m2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := rollbackTo(context.Background(), db, "mysql", migrations, applied, "m1"); err != nil {
+		t.Fatalf("rollbackTo: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet or unexpected expectations: %v", err)
+	}
+}
+
+func TestBackfillFromLegacyTable_withExistingTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []migration{{Name: "m1"}, {Name: "m2"}, {Name: "m3"}}
+
+	mock.ExpectQuery(regexp.QuoteMeta("select count(*) from schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("select name from migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("m1").AddRow("m3"))
+	mock.ExpectExec(regexp.QuoteMeta("insert into schema_migrations(name) values (?)")).
+		WithArgs("m1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("insert into schema_migrations(name) values (?)")).
+		WithArgs("m3").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	if err := backfillFromLegacyTable(context.Background(), db, "mysql", migrations); err != nil {
+		t.Fatalf("backfillFromLegacyTable: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet or unexpected expectations: %v", err)
+	}
+}
+
+func TestBackfillFromLegacyTable_withoutExistingTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []migration{{Name: "m1"}}
+
+	mock.ExpectQuery(regexp.QuoteMeta("select count(*) from schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("select name from migrations")).
+		WillReturnError(&gomysql.MySQLError{Number: mysqlErrNoSuchTable, Message: "Table 'ofac.migrations' doesn't exist"})
+
+	if err := backfillFromLegacyTable(context.Background(), db, "mysql", migrations); err != nil {
+		t.Fatalf("backfillFromLegacyTable on a fresh database should be a no-op, got: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet or unexpected expectations: %v", err)
+	}
+}
+
+func TestBackfillFromLegacyTable_propagatesTransientError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []migration{{Name: "m1"}}
+
+	mock.ExpectQuery(regexp.QuoteMeta("select count(*) from schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("select name from migrations")).
+		WillReturnError(&pq.Error{Code: "08006", Message: "connection failure"})
+
+	if err := backfillFromLegacyTable(context.Background(), db, "mysql", migrations); err == nil {
+		t.Fatal("expected a non-missing-table error to be returned, not swallowed")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet or unexpected expectations: %v", err)
+	}
+}
@@ -5,55 +5,72 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"math/rand"
+	"os"
 	"testing"
 
 	"github.com/moov-io/base/docker"
 
 	"github.com/go-kit/kit/log"
 	gomysql "github.com/go-sql-driver/mysql"
-	"github.com/lopezator/migrator"
 	"github.com/ory/dockertest"
 )
 
-var (
-	mysqlMigrator = migrator.New(
-		execsql(
-			"create_customer_name_watches",
-			`create table if not exists customer_name_watches(id varchar(40) primary key, name varchar(40), webhook varchar(512), auth_token varchar(128), created_at datetime, deleted_at datetime);`,
-		),
-		execsql(
-			"create_customer_status",
-			`create table if not exists customer_status(customer_id varchar(40), user_id varchar(40), note varchar(1024), status varchar(10), created_at datetime, deleted_at datetime);`,
-		),
-		execsql(
-			"create_customer_watches",
-			`create table if not exists customer_watches(id varchar(40) primary key, customer_id varchar(40), webhook varchar(512), auth_token varchar(128), created_at datetime, deleted_at datetime);`,
-		),
-		execsql(
-			"create_company_name_watches",
-			`create table if not exists company_name_watches(id varchar(40) primary key, name varchar(256), webhook varchar(512), auth_token varchar(128), created_at datetime, deleted_at datetime);`,
-		),
-		execsql(
-			"create_company_status",
-			`create table if not exists company_status(company_id varchar(40), user_id varchar(40), note varchar(1024), status varchar(10), created_at datetime, deleted_at datetime);`,
-		),
-		execsql(
-			"create_company_watches",
-			`create table if not exists company_watches(id varchar(40) primary key, company_id varchar(40), webhook varchar(512), auth_token varchar(128), created_at datetime, deleted_at datetime);`,
-		),
-		execsql(
-			"create_ofac_download_stats",
-			`create table if not exists ofac_download_stats(downloaded_at datetime, sdns integer, alt_names integer, addresses integer);`,
-		),
-		execsql(
-			"create_webhook_stats",
-			`create table if not exists webhook_stats(watch_id varchar(40), attempted_at datetime, status varchar(10));`,
-		),
-		execsql("add__denied_persons__to__ofac_download_stats", "alter table ofac_download_stats add column denied_persons integer not null default 0;"),
-	)
-)
+// mysqlMigrations is the ordered list of schema changes applied to a mysql
+// database. Down statements are omitted for migrations we don't consider
+// safe to reverse automatically.
+var mysqlMigrations = []migration{
+	{
+		Name: "create_customer_name_watches",
+		Up:   `create table if not exists customer_name_watches(id varchar(40) primary key, name varchar(40), webhook varchar(512), auth_token varchar(128), created_at datetime, deleted_at datetime);`,
+		Down: `drop table if exists customer_name_watches;`,
+	},
+	{
+		Name: "create_customer_status",
+		Up:   `create table if not exists customer_status(customer_id varchar(40), user_id varchar(40), note varchar(1024), status varchar(10), created_at datetime, deleted_at datetime);`,
+		Down: `drop table if exists customer_status;`,
+	},
+	{
+		Name: "create_customer_watches",
+		Up:   `create table if not exists customer_watches(id varchar(40) primary key, customer_id varchar(40), webhook varchar(512), auth_token varchar(128), created_at datetime, deleted_at datetime);`,
+		Down: `drop table if exists customer_watches;`,
+	},
+	{
+		Name: "create_company_name_watches",
+		Up:   `create table if not exists company_name_watches(id varchar(40) primary key, name varchar(256), webhook varchar(512), auth_token varchar(128), created_at datetime, deleted_at datetime);`,
+		Down: `drop table if exists company_name_watches;`,
+	},
+	{
+		Name: "create_company_status",
+		Up:   `create table if not exists company_status(company_id varchar(40), user_id varchar(40), note varchar(1024), status varchar(10), created_at datetime, deleted_at datetime);`,
+		Down: `drop table if exists company_status;`,
+	},
+	{
+		Name: "create_company_watches",
+		Up:   `create table if not exists company_watches(id varchar(40) primary key, company_id varchar(40), webhook varchar(512), auth_token varchar(128), created_at datetime, deleted_at datetime);`,
+		Down: `drop table if exists company_watches;`,
+	},
+	{
+		Name: "create_ofac_download_stats",
+		Up:   `create table if not exists ofac_download_stats(downloaded_at datetime, sdns integer, alt_names integer, addresses integer);`,
+		Down: `drop table if exists ofac_download_stats;`,
+	},
+	{
+		Name: "create_webhook_stats",
+		Up:   `create table if not exists webhook_stats(watch_id varchar(40), attempted_at datetime, status varchar(10));`,
+		Down: `drop table if exists webhook_stats;`,
+	},
+	{
+		Name: "add__denied_persons__to__ofac_download_stats",
+		Up:   "alter table ofac_download_stats add column denied_persons integer not null default 0;",
+		// No Down: reversing this would drop the denied_persons column
+		// and any data recorded in it, which we don't want a rollback
+		// to do silently.
+	},
+}
 
 type discardLogger struct{}
 
@@ -66,6 +83,7 @@ func init() {
 type mysql struct {
 	dsn    string
 	logger log.Logger
+	db     *sql.DB
 }
 
 func (my *mysql) Connect() (*sql.DB, error) {
@@ -78,15 +96,26 @@ func (my *mysql) Connect() (*sql.DB, error) {
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
+	my.db = db
 
 	// Migrate our database
-	if err := mysqlMigrator.Migrate(db); err != nil {
+	if err := my.Migrate(context.Background(), MigrateOptions{}); err != nil {
 		return nil, err
 	}
 
 	return db, nil
 }
 
+// Migrate applies or rolls back mysqlMigrations against the connected
+// database according to opts. Connect always calls this with the zero
+// MigrateOptions to bring a fresh connection fully up to date. The first
+// call against a database migrated before Watchman had its own
+// schema_migrations bookkeeping backfills it from legacyMigrationsTable so
+// already-applied migrations aren't replayed.
+func (my *mysql) Migrate(ctx context.Context, opts MigrateOptions) error {
+	return runMigrations(ctx, my.db, "mysql", mysqlMigrations, opts)
+}
+
 func mysqlConnection(logger log.Logger, user, pass string, address string, database string) *mysql {
 	dsn := fmt.Sprintf("%s:%s@%s/%s?%s", user, pass, address, database, "timeout=30s&tls=false&charset=utf8mb4&parseTime=true&sql_mode=ALLOW_INVALID_DATES")
 	return &mysql{
@@ -101,21 +130,46 @@ type TestMySQLDB struct {
 	DB *sql.DB
 
 	container *dockertest.Resource
+
+	// schema and root are set when DB was created against an already-running
+	// MySQL instance (see WATCHMAN_TEST_MYSQL_DSN) so Close can drop the
+	// per-test schema instead of tearing down a container.
+	schema string
+	root   *sql.DB
 }
 
 func (r *TestMySQLDB) Close() error {
-	r.container.Close()
+	if r.container != nil {
+		r.container.Close()
+	}
+	if r.schema != "" {
+		if _, err := r.root.Exec(fmt.Sprintf("drop database %s", r.schema)); err != nil {
+			return err
+		}
+		r.root.Close()
+	}
 	return r.DB.Close()
 }
 
 // CreateTestMySQLDB returns a TestMySQLDB which can be used in tests
 // as a clean mysql database. All migrations are ran on the db before.
 //
+// If WATCHMAN_TEST_MYSQL_DSN is set it's used to connect to an already-running
+// MySQL instance, and each test gets its own `watchman_test_<random>` schema
+// instead of a fresh dockertest container. This lets developers run
+// `docker run -d mysql:8` once and iterate quickly; CI (which leaves
+// WATCHMAN_TEST_MYSQL_DSN unset) keeps the hermetic dockertest path.
+//
 // Callers should call close on the returned *TestMySQLDB.
 func CreateTestMySQLDB(t *testing.T) *TestMySQLDB {
 	if testing.Short() {
 		t.Skip("-short flag enabled")
 	}
+
+	if dsn := os.Getenv("WATCHMAN_TEST_MYSQL_DSN"); dsn != "" {
+		return createTestMySQLDBFromDSN(t, dsn)
+	}
+
 	if !docker.Enabled() {
 		t.Skip("Docker not enabled")
 	}
@@ -157,5 +211,43 @@ func CreateTestMySQLDB(t *testing.T) *TestMySQLDB {
 	if err != nil {
 		t.Fatal(err)
 	}
-	return &TestMySQLDB{db, resource}
+	return &TestMySQLDB{DB: db, container: resource}
+}
+
+// createTestMySQLDBFromDSN connects to the MySQL instance named by dsn (a
+// go-sql-driver/mysql DSN, e.g. "moov:secret@tcp(localhost:3306)/") and
+// creates a fresh, randomly-named schema for the test to use.
+func createTestMySQLDBFromDSN(t *testing.T, dsn string) *TestMySQLDB {
+	cfg, err := gomysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("parsing WATCHMAN_TEST_MYSQL_DSN: %v", err)
+	}
+
+	root, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	// rand's package-level functions share a single, mutex-guarded source,
+	// so concurrent (t.Parallel) callers can't land on the same schema
+	// name the way two freshly-seeded rand.Rand values seeded in the same
+	// nanosecond tick could.
+	schema := fmt.Sprintf("watchman_test_%d", rand.Int63())
+	if _, err := root.Exec(fmt.Sprintf("create database %s", schema)); err != nil {
+		root.Close()
+		t.Fatal(err)
+	}
+
+	logger := log.NewNopLogger()
+	address := fmt.Sprintf("tcp(%s)", cfg.Addr)
+
+	db, err := mysqlConnection(logger, cfg.User, cfg.Passwd, address, schema).Connect()
+	if err != nil {
+		root.Close()
+		t.Fatal(err)
+	}
+	return &TestMySQLDB{DB: db, schema: schema, root: root}
 }